@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/alertmanager/types"
+)
+
+// peerHashes is the result of querying a single Alertmanager peer for its
+// currently firing alerts.
+type peerHashes struct {
+	url    string
+	hashes []uint64
+	err    error
+}
+
+// getAmHash queries the /api/v2/alerts endpoint of a single Alertmanager and
+// returns the hash of every alert it currently considers active.
+func getAmHash(url string, logger *slog.Logger) ([]uint64, error) {
+	hashes := []uint64{}
+	resp, err := http.Get(url + "/api/v2/alerts")
+	if err != nil {
+		scrapeErrors.WithLabelValues("alertmanager_api").Inc()
+		return hashes, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		scrapeErrors.WithLabelValues("alertmanager_api").Inc()
+		return hashes, err
+	}
+	var x []*types.Alert
+	err = json.Unmarshal(body, &x)
+	if err != nil {
+		scrapeErrors.WithLabelValues("alertmanager_api").Inc()
+		return hashes, err
+	}
+
+	for _, alert := range x {
+		hashes = append(hashes, hashAlert(alert))
+	}
+	logger.Debug("alertmanager fetch succeeded", "url", url, "alerts", len(hashes))
+	return hashes, nil
+}
+
+// getAmHashes queries every Alertmanager peer in parallel and returns the
+// union of the alert hashes they report, plus the per-peer results so the
+// caller can expose an up/down gauge for each one.
+func getAmHashes(urls []string, logger *slog.Logger) (map[uint64]struct{}, []peerHashes) {
+	results := make([]peerHashes, len(urls))
+
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			hashes, err := getAmHash(url, logger)
+			results[i] = peerHashes{url: url, hashes: hashes, err: err}
+		}(i, url)
+	}
+	wg.Wait()
+
+	union := map[uint64]struct{}{}
+	for _, r := range results {
+		for _, h := range r.hashes {
+			union[h] = struct{}{}
+		}
+	}
+	return union, results
+}
@@ -0,0 +1,16 @@
+package main
+
+import "strings"
+
+// stringSliceFlag implements flag.Value to collect repeated flag
+// occurrences, e.g. -alertmanager.url=a -alertmanager.url=b, into a slice.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
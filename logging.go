@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// newLogger builds the exporter's root logger from the --log.level and
+// --log.format flags.
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	switch level {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "info":
+		lvl = slog.LevelInfo
+	case "warn":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return nil, fmt.Errorf("unknown log.level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch format {
+	case "logfmt":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return nil, fmt.Errorf("unknown log.format %q", format)
+	}
+
+	return slog.New(handler), nil
+}
+
+// slogErrorLog adapts a *slog.Logger to the promhttp.Logger interface so
+// promhttp.HandlerOpts.ErrorLog logs through the same structured logger as
+// the rest of the exporter.
+type slogErrorLog struct {
+	logger *slog.Logger
+}
+
+func (l slogErrorLog) Println(v ...interface{}) {
+	l.logger.Error(fmt.Sprint(v...))
+}
@@ -1,19 +1,14 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
-	"hash/fnv"
-	"io"
-	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"os"
 	"sort"
 	"sync"
-
-	"log"
+	"time"
 
 	"github.com/cespare/xxhash"
 	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
@@ -21,16 +16,22 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
-	"github.com/prometheus/prometheus/pkg/labels"
-	"github.com/prometheus/prometheus/promql"
-
-	"github.com/matttproud/golang_protobuf_extensions/pbutil"
 )
 
-type state map[string]*pb.MeshEntry
+var (
+	address       = flag.String("address", ":59599", "address")
+	logLevel      = flag.String("log.level", "info", "Only log messages with the given severity or above. One of: [debug, info, warn, error]")
+	logFormat     = flag.String("log.format", "logfmt", "Output format of log messages. One of: [logfmt, json]")
+	amURLs        stringSliceFlag
+	nflogPaths    stringSliceFlag
+	includeLabels stringSliceFlag
+)
 
-var verbose = flag.Bool("verbose", false, "log debug messages")
-var address = flag.String("address", ":59599", "address")
+func init() {
+	flag.Var(&amURLs, "alertmanager.url", "Alertmanager base URL to query, e.g. http://10.0.0.1:9093. May be repeated once per HA peer.")
+	flag.Var(&nflogPaths, "nflog.path", "Path to a peer's nflog snapshot file. May be a glob (e.g. /data/*/nflog) and may be repeated once per peer.")
+	flag.Var(&includeLabels, "include-label", "Alert label to attach to nflogerror_alert_stuck_* metrics. May be repeated; bounds metric cardinality.")
+}
 
 // stateKey returns a string key for a log entry consisting of the group key
 // and receiver.
@@ -45,32 +46,38 @@ func receiverKey(r *pb.Receiver) string {
 func main() {
 	flag.Parse()
 
-	prometheus.Register(&Exporter{})
-
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(*address, nil))
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-}
+	urls := amURLs
+	paths := nflogPaths
+	if len(urls) == 0 {
+		urls = stringSliceFlag{"http://127.0.0.1:9093"}
+	}
+	if len(paths) == 0 && flag.Arg(0) != "" {
+		paths = stringSliceFlag{flag.Arg(0)}
+	}
 
-func decodeState(r io.Reader) (state, error) {
-	st := state{}
-	for {
-		var e pb.MeshEntry
-		_, err := pbutil.ReadDelimited(r, &e)
-		if err == nil {
-			if e.Entry == nil || e.Entry.Receiver == nil {
-				return nil, errors.New("oops")
-			}
-			st[stateKey(string(e.Entry.GroupKey), e.Entry.Receiver)] = &e
-			continue
-		}
-		if err == io.EOF {
-			break
-		}
-		return nil, err
+	nflog, err := newNflogWatcher(paths, logger)
+	if err != nil {
+		logger.Error("failed to start nflog watcher", "err", err)
+		os.Exit(1)
 	}
-	return st, nil
+	prometheus.MustRegister(nflog)
+
+	prometheus.Register(NewExporter(urls, includeLabels, nflog, logger))
 
+	http.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		ErrorLog:      slogErrorLog{logger: logger},
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+	if err := http.ListenAndServe(*address, nil); err != nil {
+		logger.Error("server exited", "err", err)
+		os.Exit(1)
+	}
 }
 
 func hashAlert(a *types.Alert) uint64 {
@@ -114,106 +121,96 @@ func putHashBuffer(b []byte) {
 	hashBuffers.Put(b)
 }
 
-func getAmHash() ([]uint64, error) {
-	hashes := []uint64{}
-	resp, err := http.Get("http://127.0.0.1:9093/api/v2/alerts")
-	if err != nil {
-		return hashes, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return hashes, err
-	}
-	var x []*types.Alert
-	err = json.Unmarshal(body, &x)
-	if err != nil {
-		return hashes, err
-	}
+// Exporter collects nflogerror metrics across every configured Alertmanager
+// peer and nflog file.
+type Exporter struct {
+	amURLs        []string
+	includeLabels []string
+	logger        *slog.Logger
+	nflog         *nflogWatcher
 
-	for _, alert := range x {
-		hashes = append(hashes, hashAlert(alert))
-	}
-	return hashes, nil
-}
+	up *prometheus.GaugeVec
 
-func getNflogHash(file string) (state, error) {
-	r, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	return decodeState(r)
+	stuckInfo      *prometheus.Desc
+	stuckTimestamp *prometheus.Desc
+	stuckExpiresAt *prometheus.Desc
 }
 
-func gauge(l labels.Labels, hash string) *prometheus.GaugeVec {
-	var x []string
-	for _, n := range l {
-		x = append(x, n.Name)
+// NewExporter creates an Exporter that queries amURLs for currently firing
+// alerts and reads cached nflog state from nflog. includeLabels selects
+// which alert labels are attached to the nflogerror_alert_stuck_* metrics,
+// bounding their cardinality.
+func NewExporter(amURLs, includeLabels []string, nflog *nflogWatcher, logger *slog.Logger) *Exporter {
+	sort.Strings(includeLabels)
+	info, timestamp, expiresAt := newStuckDescs(includeLabels)
+	return &Exporter{
+		amURLs:        amURLs,
+		includeLabels: includeLabels,
+		logger:        logger,
+		nflog:         nflog,
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nflogerror_alertmanager_up",
+			Help: "Whether the last scrape of this Alertmanager peer's /api/v2/alerts succeeded.",
+		}, []string{"url"}),
+		stuckInfo:      info,
+		stuckTimestamp: timestamp,
+		stuckExpiresAt: expiresAt,
 	}
-	return prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: fmt.Sprintf("ALERTS_IN_NFLOG_NOT_FIRING_%s", hash),
-			Help: "Alerts in NFLOG but no longer firing.",
-		},
-		x,
-	)
-}
-
-type Exporter struct {
 }
 
 func (e *Exporter) Describe(c chan<- *prometheus.Desc) {
-	//prometheus.DescribeByCollect(e, c)
+	e.up.Describe(c)
+	c <- e.stuckInfo
+	c <- e.stuckTimestamp
+	c <- e.stuckExpiresAt
 }
 
-func (*Exporter) Collect(c chan<- prometheus.Metric) {
-	currentAlerts, err := getAmHash()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+func (e *Exporter) Collect(c chan<- prometheus.Metric) {
+	start := time.Now()
+	defer func() {
+		scrapeDuration.Observe(time.Since(start).Seconds())
+	}()
 
-	s, err := getNflogHash(flag.Arg(0))
-	if err != nil {
-		fmt.Println(err)
-		return
+	currentAlerts, amResults := getAmHashes(e.amURLs, e.logger)
+
+	for _, r := range amResults {
+		if r.err != nil {
+			e.logger.Error("alertmanager fetch failed", "url", r.url, "err", r.err)
+			e.up.WithLabelValues(r.url).Set(0)
+			continue
+		}
+		e.up.WithLabelValues(r.url).Set(1)
 	}
+	e.up.Collect(c)
+
+	merged := e.nflog.merged()
 
-	for _, mesh := range s {
+	var matched, stuck int
+	for _, en := range merged {
+		mesh := en.mesh
 		for _, a := range mesh.Entry.FiringAlerts {
-			var found bool
-			for _, u := range currentAlerts {
-				if u == a {
-					found = true
-				}
+			_, found := currentAlerts[a]
+			if found {
+				matched++
 			}
-			var l labels.Labels
-			var found2 bool
-			for i := 0; i < len(mesh.Entry.GroupKey); i++ {
-				var err error
-				l, err = promql.ParseMetric(string(mesh.Entry.GroupKey[len(mesh.Entry.GroupKey)-i:]))
-				if err == nil {
-					found2 = true
-					break
+			if en.hasLabels && !found {
+				stuck++
+				labelValues := []string{
+					string(mesh.Entry.GroupKey),
+					receiverKey(mesh.Entry.Receiver),
+					mesh.Entry.Receiver.Integration,
+					model.Fingerprint(a).String(),
 				}
-			}
-			if found2 && !found {
-				g := gauge(l, fmt.Sprintf("%v_%v_count", hashBytes(mesh.Entry.GroupKey), a))
-				g.With(l.Map()).Inc()
-				g.Collect(c)
-				g2 := gauge(l, fmt.Sprintf("%v_%v_timestamp_seconds", hashBytes(mesh.Entry.GroupKey), a))
-				g2.With(l.Map()).Set(float64(mesh.Entry.Timestamp.UnixNano()) / 1e9)
-				g2.Collect(c)
-				g3 := gauge(l, fmt.Sprintf("%v_%v_expires_at", hashBytes(mesh.Entry.GroupKey), a))
-				g3.With(l.Map()).Set(float64(mesh.ExpiresAt.UnixNano()) / 1e9)
-				g3.Collect(c)
+				for _, ln := range e.includeLabels {
+					labelValues = append(labelValues, en.labels.Get(ln))
+				}
+
+				c <- prometheus.MustNewConstMetric(e.stuckInfo, prometheus.GaugeValue, 1, labelValues...)
+				c <- prometheus.MustNewConstMetric(e.stuckTimestamp, prometheus.GaugeValue, float64(mesh.Entry.Timestamp.UnixNano())/1e9, labelValues...)
+				c <- prometheus.MustNewConstMetric(e.stuckExpiresAt, prometheus.GaugeValue, float64(mesh.ExpiresAt.UnixNano())/1e9, labelValues...)
 			}
 		}
 	}
-}
 
-func hashBytes(s []byte) uint32 {
-	h := fnv.New32a()
-	h.Write(s)
-	return h.Sum32()
+	e.logger.Debug("scrape complete", "nflog_entries_loaded", len(merged), "firing_alerts_matched", matched, "stuck_alerts_emitted", stuck)
 }
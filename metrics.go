@@ -0,0 +1,33 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// stuckLabelNames returns the fixed label names shared by every
+// nflogerror_alert_stuck_* metric, followed by the configured
+// --include-label alert labels. Keeping the variable portion explicit and
+// operator-controlled bounds the cardinality of these metrics.
+func stuckLabelNames(includeLabels []string) []string {
+	names := []string{"group_key", "receiver", "integration", "fingerprint"}
+	return append(names, includeLabels...)
+}
+
+func newStuckDescs(includeLabels []string) (info, timestamp, expiresAt *prometheus.Desc) {
+	labelNames := stuckLabelNames(includeLabels)
+
+	info = prometheus.NewDesc(
+		"nflogerror_alert_stuck_info",
+		"An alert is present in the Alertmanager notification log but is no longer firing.",
+		labelNames, nil,
+	)
+	timestamp = prometheus.NewDesc(
+		"nflogerror_alert_stuck_timestamp_seconds",
+		"Timestamp at which the notification log entry for this alert was last updated.",
+		labelNames, nil,
+	)
+	expiresAt = prometheus.NewDesc(
+		"nflogerror_alert_stuck_expires_at_seconds",
+		"Timestamp at which the notification log entry for this alert expires.",
+		labelNames, nil,
+	)
+	return
+}
@@ -0,0 +1,23 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// scrapeErrors counts failures encountered while producing a scrape,
+// broken down by the stage that failed. It is registered independently of
+// the Exporter so that it keeps counting across scrapes instead of being
+// reset to the state of the last Collect call.
+var scrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nflogerror_scrape_errors_total",
+	Help: "Number of errors encountered while scraping, by source.",
+}, []string{"source"})
+
+// scrapeDuration tracks how long a full Collect call takes, across every
+// configured Alertmanager peer and nflog file.
+var scrapeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name: "nflogerror_scrape_duration_seconds",
+	Help: "Time spent collecting a single scrape.",
+})
+
+func init() {
+	prometheus.MustRegister(scrapeErrors, scrapeDuration)
+}
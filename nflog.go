@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// nflogEntry wraps a decoded mesh entry together with the labels parsed out
+// of its group key, computed once at load time rather than on every scrape.
+type nflogEntry struct {
+	mesh      *pb.MeshEntry
+	labels    labels.Labels
+	hasLabels bool
+}
+
+type state map[string]*nflogEntry
+
+// parseGroupKeyLabels recovers the alert labels encoded in an Alertmanager
+// group key. The group key is a concatenation of label matchers with no
+// reliable delimiter, so, as before, this retries progressively longer
+// suffixes, up to the whole key, until one parses as a valid metric.
+func parseGroupKeyLabels(groupKey []byte, logger *slog.Logger) (labels.Labels, bool) {
+	var lastErr error
+	for i := 0; i <= len(groupKey); i++ {
+		l, err := promql.ParseMetric(string(groupKey[len(groupKey)-i:]))
+		if err == nil {
+			return l, true
+		}
+		lastErr = err
+	}
+	scrapeErrors.WithLabelValues("parse_group_key").Inc()
+	logger.Debug("group key parse failed", "group_key", string(groupKey), "err", lastErr)
+	return nil, false
+}
+
+// loadNflogFile reads and decodes a single nflog file on disk.
+func loadNflogFile(file string, logger *slog.Logger) (state, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		scrapeErrors.WithLabelValues("nflog_file").Inc()
+		return nil, err
+	}
+	defer r.Close()
+	st, err := decodeState(r, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("nflog file decoded", "file", file, "entries", len(st))
+	return st, nil
+}
+
+// expandNflogPaths resolves a list of paths, each of which may be a glob
+// pattern or a directory (e.g. a directory's worth of peer snapshots), into
+// a flat list of concrete file paths.
+func expandNflogPaths(paths []string) ([]string, error) {
+	var out []string
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			matches, err := filepath.Glob(filepath.Join(p, "*"))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, matches...)
+			continue
+		}
+
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			// Not a glob, or a glob with no matches yet: keep it as-is so a
+			// missing-file error surfaces per-peer instead of being silently
+			// dropped.
+			out = append(out, p)
+			continue
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+// mergeState merges src into dst, keyed by stateKey. When both maps contain
+// an entry for the same key (e.g. the same group/receiver gossiped to
+// multiple peers), the entry with the most recent Timestamp wins.
+func mergeState(dst, src state) {
+	for k, e := range src {
+		existing, ok := dst[k]
+		if !ok || e.mesh.Entry.Timestamp.After(existing.mesh.Entry.Timestamp) {
+			dst[k] = e
+		}
+	}
+}
+
+func decodeState(r io.Reader, logger *slog.Logger) (state, error) {
+	st := state{}
+	for {
+		var e pb.MeshEntry
+		_, err := pbutil.ReadDelimited(r, &e)
+		if err == nil {
+			if e.Entry == nil || e.Entry.Receiver == nil {
+				scrapeErrors.WithLabelValues("decode").Inc()
+				return nil, errors.New("oops")
+			}
+			l, ok := parseGroupKeyLabels(e.Entry.GroupKey, logger)
+			st[stateKey(string(e.Entry.GroupKey), e.Entry.Receiver)] = &nflogEntry{
+				mesh:      &e,
+				labels:    l,
+				hasLabels: ok,
+			}
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		scrapeErrors.WithLabelValues("decode").Inc()
+		return nil, err
+	}
+	return st, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func meshEntry(ts time.Time) *pb.MeshEntry {
+	return &pb.MeshEntry{
+		Entry: &pb.Entry{
+			GroupKey:  []byte(`{alertname="Foo"}`),
+			Receiver:  &pb.Receiver{GroupName: "g", Integration: "email", Idx: 0},
+			Timestamp: ts,
+		},
+		ExpiresAt: ts.Add(time.Hour),
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	now := time.Now()
+	const key = "k"
+
+	cases := []struct {
+		name     string
+		dst      state
+		src      state
+		wantTime time.Time
+		keepDst  bool
+	}{
+		{
+			name:     "missing key is added",
+			dst:      state{},
+			src:      state{key: &nflogEntry{mesh: meshEntry(now)}},
+			wantTime: now,
+		},
+		{
+			name:     "src older than dst is ignored",
+			dst:      state{key: &nflogEntry{mesh: meshEntry(now)}},
+			src:      state{key: &nflogEntry{mesh: meshEntry(now.Add(-time.Minute))}},
+			wantTime: now,
+		},
+		{
+			name:     "src newer than dst wins",
+			dst:      state{key: &nflogEntry{mesh: meshEntry(now)}},
+			src:      state{key: &nflogEntry{mesh: meshEntry(now.Add(time.Minute))}},
+			wantTime: now.Add(time.Minute),
+		},
+		{
+			name:     "equal timestamps keep dst",
+			dst:      state{key: &nflogEntry{mesh: meshEntry(now)}},
+			src:      state{key: &nflogEntry{mesh: meshEntry(now)}},
+			wantTime: now,
+			keepDst:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			orig := tc.dst[key]
+			mergeState(tc.dst, tc.src)
+			got := tc.dst[key].mesh.Entry.Timestamp
+			if !got.Equal(tc.wantTime) {
+				t.Errorf("got timestamp %v, want %v", got, tc.wantTime)
+			}
+			if tc.keepDst && tc.dst[key] != orig {
+				t.Error("dst entry was replaced, want it kept")
+			}
+		})
+	}
+}
+
+func TestParseGroupKeyLabels(t *testing.T) {
+	logger := discardLogger()
+
+	l, ok := parseGroupKeyLabels([]byte(`{alertname="Foo",job="bar"}`), logger)
+	if !ok {
+		t.Fatal("expected a valid group key to parse")
+	}
+	if got := l.Get("alertname"); got != "Foo" {
+		t.Errorf("alertname label = %q, want %q", got, "Foo")
+	}
+
+	if _, ok := parseGroupKeyLabels([]byte("not a metric at all {{{"), logger); ok {
+		t.Error("expected an unparsable group key to fail")
+	}
+}
+
+func TestDecodeState(t *testing.T) {
+	logger := discardLogger()
+
+	var buf bytes.Buffer
+	valid := meshEntry(time.Now())
+	if _, err := pbutil.WriteDelimited(&buf, valid); err != nil {
+		t.Fatalf("failed to encode test entry: %v", err)
+	}
+
+	st, err := decodeState(&buf, logger)
+	if err != nil {
+		t.Fatalf("decodeState returned error for valid input: %v", err)
+	}
+	if len(st) != 1 {
+		t.Fatalf("got %d entries, want 1", len(st))
+	}
+
+	var invalidBuf bytes.Buffer
+	invalid := &pb.MeshEntry{Entry: &pb.Entry{GroupKey: []byte("x")}}
+	if _, err := pbutil.WriteDelimited(&invalidBuf, invalid); err != nil {
+		t.Fatalf("failed to encode invalid test entry: %v", err)
+	}
+
+	if _, err := decodeState(&invalidBuf, logger); err == nil {
+		t.Error("expected decodeState to error on an entry with no receiver")
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+func init() {
+	prometheus.MustRegister(version.NewCollector("nflogerror"))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nflogWatcher keeps an in-memory, per-file cache of decoded nflog state and
+// refreshes it by watching the directory containing each file with fsnotify
+// (which also catches Alertmanager's atomic rename on snapshot rewrite) and
+// reloading only the tracked files, instead of re-decoding on every scrape.
+type nflogWatcher struct {
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	perFile map[string]state
+
+	watcher *fsnotify.Watcher
+
+	fileEntries    *prometheus.GaugeVec
+	reloadTotal    *prometheus.CounterVec
+	lastReloadTime *prometheus.GaugeVec
+}
+
+// newNflogWatcher expands paths, loads each matching file once, and starts
+// watching them for changes in the background.
+func newNflogWatcher(paths []string, logger *slog.Logger) (*nflogWatcher, error) {
+	files, err := expandNflogPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &nflogWatcher{
+		logger:  logger,
+		perFile: map[string]state{},
+		watcher: fw,
+		fileEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nflogerror_nflog_file_entries",
+			Help: "Number of notification log entries decoded from this nflog file as of its last reload.",
+		}, []string{"file"}),
+		reloadTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nflogerror_nflog_reload_total",
+			Help: "Number of times this nflog file has been reloaded.",
+		}, []string{"file"}),
+		lastReloadTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nflogerror_nflog_last_reload_timestamp_seconds",
+			Help: "Unix timestamp of the last successful reload of this nflog file.",
+		}, []string{"file"}),
+	}
+
+	dirs := map[string]struct{}{}
+	for _, f := range files {
+		dirs[filepath.Dir(f)] = struct{}{}
+		w.reload(f)
+	}
+	for d := range dirs {
+		if err := fw.Add(d); err != nil {
+			logger.Error("failed to watch nflog directory", "dir", d, "err", err)
+		}
+	}
+
+	go w.run(files)
+
+	return w, nil
+}
+
+func (w *nflogWatcher) run(files []string) {
+	watched := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		watched[f] = struct{}{}
+	}
+
+	for {
+		select {
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if _, tracked := watched[ev.Name]; !tracked {
+				continue
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			w.reload(ev.Name)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.logger.Error("nflog watcher error", "err", err)
+		}
+	}
+}
+
+func (w *nflogWatcher) reload(file string) {
+	st, err := loadNflogFile(file, w.logger)
+	if err != nil {
+		w.logger.Error("nflog file reload failed", "file", file, "err", err)
+		return
+	}
+
+	w.mu.Lock()
+	w.perFile[file] = st
+	w.mu.Unlock()
+
+	w.fileEntries.WithLabelValues(file).Set(float64(len(st)))
+	w.reloadTotal.WithLabelValues(file).Inc()
+	w.lastReloadTime.WithLabelValues(file).Set(float64(time.Now().Unix()))
+}
+
+// merged returns the union of every watched file's cached state, resolving
+// conflicts by Timestamp. It only takes an RLock: the expensive protobuf
+// decoding and label parsing already happened in reload.
+func (w *nflogWatcher) merged() state {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	merged := state{}
+	for _, st := range w.perFile {
+		mergeState(merged, st)
+	}
+	return merged
+}
+
+func (w *nflogWatcher) Describe(c chan<- *prometheus.Desc) {
+	w.fileEntries.Describe(c)
+	w.reloadTotal.Describe(c)
+	w.lastReloadTime.Describe(c)
+}
+
+func (w *nflogWatcher) Collect(c chan<- prometheus.Metric) {
+	w.fileEntries.Collect(c)
+	w.reloadTotal.Collect(c)
+	w.lastReloadTime.Collect(c)
+}